@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileBackend stores objects as plain files under a root directory on the
+// local filesystem. Keys are treated as slash-separated relative paths.
+type fileBackend struct {
+	root string
+}
+
+func newFileBackend(cfg Config) (Backend, error) {
+	if cfg.FileRoot == "" {
+		return nil, fmt.Errorf("file backend: missing file root")
+	}
+	if err := os.MkdirAll(cfg.FileRoot, 0755); err != nil {
+		return nil, fmt.Errorf("file backend: %w", err)
+	}
+	return &fileBackend{root: cfg.FileRoot}, nil
+}
+
+func (b *fileBackend) resolve(key string) (string, error) {
+	path := filepath.Join(b.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(b.root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("file backend: key %q escapes root directory", key)
+	}
+	return path, nil
+}
+
+func (b *fileBackend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := b.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (b *fileBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := b.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (b *fileBackend) Head(ctx context.Context, key string) error {
+	path, err := b.resolve(key)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(path)
+	return err
+}
+
+func (b *fileBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(b.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *fileBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.resolve(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
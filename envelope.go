@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Envelope encryption parameters. The backup is protected with a fresh,
+// random data encryption key (DEK) so the bulk ciphertext never touches a
+// passphrase-derived key directly; the DEK itself is wrapped with a
+// key-encryption key (KEK) derived from the operator's passphrase. This
+// means a backup can be restored with only the passphrase, even if the
+// recipient's PGP key is lost.
+//
+// The payload itself is split into fixed-size chunks, each sealed with its
+// own AES-GCM nonce, so arbitrarily large backups can be encrypted and
+// decrypted while holding at most one chunk in memory at a time.
+const (
+	envelopeVersion = 1
+	kdfArgon2id     = "argon2id"
+
+	dekSize   = 32 // AES-256
+	saltSize  = 16
+	nonceSize = 12 // AES-GCM standard nonce size
+
+	argonTime    uint32 = 3
+	argonMemory  uint32 = 64 * 1024 // KiB
+	argonThreads uint8  = 4
+
+	// envelopeChunkSize is the amount of plaintext sealed per chunk. It
+	// bounds the memory EncryptAndSign/VerifyAndDecrypt need regardless of
+	// the backup's total size.
+	envelopeChunkSize = 4 << 20 // 4 MiB
+
+	chunkFrameData = byte(1)
+	chunkFrameEnd  = byte(0)
+
+	// maxFrameSize bounds the length readFrame will trust from an
+	// unauthenticated 4-byte length prefix, before any of the frame's
+	// contents have been read or verified. The largest legitimate frame is
+	// a sealed chunk (envelopeChunkSize plus the AES-GCM tag and a little
+	// slack); the envelope header and detached signature frames are far
+	// smaller. A corrupted or adversarial prefix claiming up to 4 GiB would
+	// otherwise force a single huge allocation per frame.
+	maxFrameSize = envelopeChunkSize + 1<<16
+)
+
+// envelopeHeader is the framed, JSON-encoded metadata that precedes the
+// chunked ciphertext in an envelope-encrypted backup artifact. []byte fields
+// are base64-encoded by encoding/json.
+type envelopeHeader struct {
+	Version      int    `json:"version"`
+	KDF          string `json:"kdf"`
+	Salt         []byte `json:"salt"`
+	ArgonTime    uint32 `json:"argon_params_time"`
+	ArgonMemory  uint32 `json:"argon_params_memory"`
+	ArgonThreads uint8  `json:"argon_params_threads"`
+	WrappedDEK   []byte `json:"wrapped_dek"`
+	DEKNonce     []byte `json:"dek_nonce"`
+	PayloadNonce []byte `json:"payload_nonce"`
+}
+
+// sealEnvelopeStream encrypts the data read from r under a freshly generated
+// DEK, wraps the DEK with a KEK derived from passphrase, and streams the
+// framed header followed by the chunked payload ciphertext to w. At most one
+// envelopeChunkSize-sized chunk is held in memory at a time.
+func sealEnvelopeStream(w io.Writer, r io.Reader, passphrase string) error {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return fmt.Errorf("generate DEK: %w", err)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generate salt: %w", err)
+	}
+	kek := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, dekSize)
+
+	dekNonce := make([]byte, nonceSize)
+	if _, err := rand.Read(dekNonce); err != nil {
+		return fmt.Errorf("generate DEK nonce: %w", err)
+	}
+	wrappedDEK, err := gcmSeal(kek, dekNonce, dek)
+	if err != nil {
+		return fmt.Errorf("wrap DEK: %w", err)
+	}
+
+	payloadNonce := make([]byte, nonceSize)
+	if _, err := rand.Read(payloadNonce); err != nil {
+		return fmt.Errorf("generate payload nonce: %w", err)
+	}
+
+	header := envelopeHeader{
+		Version:      envelopeVersion,
+		KDF:          kdfArgon2id,
+		Salt:         salt,
+		ArgonTime:    argonTime,
+		ArgonMemory:  argonMemory,
+		ArgonThreads: argonThreads,
+		WrappedDEK:   wrappedDEK,
+		DEKNonce:     dekNonce,
+		PayloadNonce: payloadNonce,
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("marshal envelope header: %w", err)
+	}
+	if err := writeFrame(w, headerBytes); err != nil {
+		return fmt.Errorf("write envelope header: %w", err)
+	}
+
+	dekBlock, err := aes.NewCipher(dek)
+	if err != nil {
+		return fmt.Errorf("init DEK cipher: %w", err)
+	}
+	dekGCM, err := cipher.NewGCM(dekBlock)
+	if err != nil {
+		return fmt.Errorf("init DEK GCM: %w", err)
+	}
+
+	chunk := make([]byte, envelopeChunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, readErr := io.ReadFull(r, chunk)
+		if n > 0 {
+			ciphertext := dekGCM.Seal(nil, chunkNonce(payloadNonce, counter), chunk[:n], nil)
+			if err := writeChunkFrame(w, chunkFrameData, ciphertext); err != nil {
+				return fmt.Errorf("write chunk %d: %w", counter, err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("read plaintext chunk %d: %w", counter, readErr)
+		}
+	}
+
+	return writeChunkFrame(w, chunkFrameEnd, nil)
+}
+
+// openEnvelopeStream reads a framed header and chunked ciphertext written by
+// sealEnvelopeStream from r, decrypting each chunk in turn and writing the
+// recovered plaintext to w.
+func openEnvelopeStream(w io.Writer, r io.Reader, passphrase string) error {
+	headerBytes, err := readFrame(r)
+	if err != nil {
+		return fmt.Errorf("read envelope header: %w", err)
+	}
+
+	var header envelopeHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return fmt.Errorf("unmarshal envelope header: %w", err)
+	}
+	if header.KDF != kdfArgon2id {
+		return fmt.Errorf("unsupported KDF %q", header.KDF)
+	}
+	if len(header.PayloadNonce) != nonceSize {
+		return fmt.Errorf("invalid payload nonce length %d", len(header.PayloadNonce))
+	}
+	if len(header.DEKNonce) != nonceSize {
+		return fmt.Errorf("invalid DEK nonce length %d", len(header.DEKNonce))
+	}
+
+	kek := argon2.IDKey([]byte(passphrase), header.Salt, header.ArgonTime, header.ArgonMemory, header.ArgonThreads, dekSize)
+	dek, err := gcmOpen(kek, header.DEKNonce, header.WrappedDEK)
+	if err != nil {
+		return fmt.Errorf("unwrap DEK (wrong passphrase?): %w", err)
+	}
+
+	dekBlock, err := aes.NewCipher(dek)
+	if err != nil {
+		return fmt.Errorf("init DEK cipher: %w", err)
+	}
+	dekGCM, err := cipher.NewGCM(dekBlock)
+	if err != nil {
+		return fmt.Errorf("init DEK GCM: %w", err)
+	}
+
+	for counter := uint64(0); ; counter++ {
+		frameType, ciphertext, err := readChunkFrame(r)
+		if err != nil {
+			return fmt.Errorf("read chunk %d: %w", counter, err)
+		}
+		if frameType == chunkFrameEnd {
+			return nil
+		}
+
+		plaintext, err := dekGCM.Open(nil, chunkNonce(header.PayloadNonce, counter), ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("decrypt chunk %d: %w", counter, err)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return fmt.Errorf("write plaintext chunk %d: %w", counter, err)
+		}
+	}
+}
+
+// chunkNonce derives the per-chunk AES-GCM nonce from the stream's random
+// base nonce and the chunk's sequence number, keeping the first 4 bytes of
+// base as a per-stream random prefix and the remaining 8 bytes as a counter.
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, base[:4])
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// gcmSeal encrypts plaintext with AES-256-GCM under key and nonce.
+func gcmSeal(key, nonce, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// gcmOpen decrypts ciphertext with AES-256-GCM under key and nonce.
+func gcmOpen(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// writeFrame writes a 4-byte big-endian length prefix followed by data.
+func writeFrame(w io.Writer, data []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame reads a 4-byte big-endian length prefix followed by that many
+// bytes. The length is rejected if it exceeds maxFrameSize, before any
+// allocation or read of the frame body is attempted.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("frame length %d exceeds maximum %d", n, maxFrameSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeChunkFrame writes a 1-byte frame type, a 4-byte big-endian length
+// prefix, and data. frameType distinguishes a data chunk from the stream
+// terminator so a legitimately empty chunk is never mistaken for EOF.
+func writeChunkFrame(w io.Writer, frameType byte, data []byte) error {
+	if _, err := w.Write([]byte{frameType}); err != nil {
+		return err
+	}
+	return writeFrame(w, data)
+}
+
+// readChunkFrame reads a frame written by writeChunkFrame.
+func readChunkFrame(r io.Reader) (byte, []byte, error) {
+	var frameType [1]byte
+	if _, err := io.ReadFull(r, frameType[:]); err != nil {
+		return 0, nil, err
+	}
+	data, err := readFrame(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return frameType[0], data, nil
+}
@@ -3,86 +3,397 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/thomasdseao/go-secure-backup-s3/backend"
+	"github.com/thomasdseao/go-secure-backup-s3/secret"
 )
 
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  go-secure-backup-s3 backup [flags]")
+	fmt.Println("  go-secure-backup-s3 restore [flags]")
+}
+
+// backendFlags holds the CLI flags shared by the backup and restore
+// subcommands for selecting and configuring a storage backend.
+type backendFlags struct {
+	name              string
+	bucket            string
+	region            string
+	accessKey         string
+	secretKey         string
+	endpoint          string
+	insecure          bool
+	signatureVersion  string
+	pathStyle         bool
+	fileRoot          string
+	partSize          int64
+	uploadConcurrency int
+	sse               string
+	sseKMSKeyID       string
+	sseCKey           string
+
+	// sseCKeyBytes is the base64-decoded form of sseCKey, populated by
+	// validate().
+	sseCKeyBytes []byte
+}
+
+// registerBackendFlags registers the backend selection and configuration
+// flags on fs.
+func registerBackendFlags(fs *flag.FlagSet) *backendFlags {
+	bf := &backendFlags{}
+	fs.StringVar(&bf.name, "backend", "s3", "Storage backend to use: s3, minio, or file")
+	fs.StringVar(&bf.bucket, "bucket", "", "Bucket name (s3, minio backends)")
+	fs.StringVar(&bf.region, "aws-region", "", "AWS bucket region (s3 backend)")
+	fs.StringVar(&bf.accessKey, "aws-access-key", "", "AWS access key (s3, minio backends), or a vault:// reference to it")
+	fs.StringVar(&bf.secretKey, "aws-secret-key", "", "AWS secret key (s3, minio backends), or a vault:// reference to it")
+	fs.StringVar(&bf.endpoint, "endpoint", "", "S3-compatible endpoint host:port (minio backend)")
+	fs.BoolVar(&bf.insecure, "insecure", false, "Use HTTP instead of HTTPS when talking to endpoint (minio backend)")
+	fs.StringVar(&bf.signatureVersion, "signature-version", "v4", "Request signature version: v2 or v4 (minio backend)")
+	fs.BoolVar(&bf.pathStyle, "path-style", false, "Use path-style bucket addressing instead of virtual-hosted (minio backend)")
+	fs.StringVar(&bf.fileRoot, "file-root", "", "Root directory to store backups under (file backend)")
+	fs.Int64Var(&bf.partSize, "part-size", backend.DefaultPartSize, "Multipart upload part size in bytes (s3, minio backends)")
+	fs.IntVar(&bf.uploadConcurrency, "upload-concurrency", backend.DefaultUploadConcurrency, "Number of multipart upload parts to send concurrently (s3, minio backends)")
+	fs.StringVar(&bf.sse, "sse", "", "Server-side encryption to apply in addition to the pipeline's own encryption: none, aes256, kms, or c (s3, minio backends)")
+	fs.StringVar(&bf.sseKMSKeyID, "sse-kms-key-id", "", "KMS key ID to use when --sse=kms")
+	fs.StringVar(&bf.sseCKey, "sse-c-key", "", "Base64-encoded 32-byte customer key to use when --sse=c, or a vault:// reference to it")
+	return bf
+}
+
+// build constructs the Backend selected by bf.name.
+func (bf *backendFlags) build() (backend.Backend, error) {
+	return backend.New(bf.name, backend.Config{
+		Bucket:            bf.bucket,
+		Region:            bf.region,
+		AccessKey:         bf.accessKey,
+		SecretKey:         bf.secretKey,
+		Endpoint:          bf.endpoint,
+		Insecure:          bf.insecure,
+		SignatureVersion:  bf.signatureVersion,
+		PathStyle:         bf.pathStyle,
+		FileRoot:          bf.fileRoot,
+		PartSize:          bf.partSize,
+		UploadConcurrency: bf.uploadConcurrency,
+		SSEMode:           bf.sse,
+		SSEKMSKeyID:       bf.sseKMSKeyID,
+		SSECKey:           bf.sseCKeyBytes,
+	})
+}
+
+// vaultFlags holds the CLI flags used to authenticate against HashiCorp
+// Vault when a flag value is a "vault://<mount>/<path>#<field>" reference.
+type vaultFlags struct {
+	addr     string
+	token    string
+	roleID   string
+	secretID string
+}
+
+// registerVaultFlags registers the Vault connection and authentication flags
+// on fs.
+func registerVaultFlags(fs *flag.FlagSet) *vaultFlags {
+	vf := &vaultFlags{}
+	fs.StringVar(&vf.addr, "vault-addr", "", "Vault server address, required if any flag uses a vault:// reference")
+	fs.StringVar(&vf.token, "vault-token", "", "Vault token (falls back to the VAULT_TOKEN environment variable)")
+	fs.StringVar(&vf.roleID, "vault-role-id", "", "Vault AppRole role ID, used if --vault-token and VAULT_TOKEN are unset")
+	fs.StringVar(&vf.secretID, "vault-secret-id", "", "Vault AppRole secret ID, used if --vault-token and VAULT_TOKEN are unset")
+	return vf
+}
+
+// buildResolver constructs the secret.Resolver that flag values are resolved
+// through. A "vault" provider is registered only when vf.addr is set, so
+// vault:// references fail fast with a clear error rather than silently
+// resolving to themselves when Vault hasn't been configured.
+func (vf *vaultFlags) buildResolver() (*secret.Resolver, error) {
+	providers := map[string]secret.Provider{}
+
+	if vf.addr != "" {
+		token := vf.token
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		provider, err := secret.NewVaultProvider(secret.VaultConfig{
+			Addr:     vf.addr,
+			Token:    token,
+			RoleID:   vf.roleID,
+			SecretID: vf.secretID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers["vault"] = provider
+	}
+
+	return secret.NewResolver(providers), nil
+}
+
+// resolveSecrets resolves each of values in place through resolver, so flags
+// may be given as plain values or vault:// references.
+func resolveSecrets(ctx context.Context, resolver *secret.Resolver, values ...*string) error {
+	for _, v := range values {
+		resolved, err := resolver.Resolve(ctx, *v)
+		if err != nil {
+			return err
+		}
+		*v = resolved
+	}
+	return nil
+}
+
+// validate checks that bf carries the settings its selected backend needs.
+func (bf *backendFlags) validate() error {
+	switch bf.name {
+	case "s3":
+		if bf.bucket == "" {
+			return fmt.Errorf("Invalid or missing S3 bucket name")
+		}
+		if bf.region == "" {
+			return fmt.Errorf("Invalid or missing S3 bucket region")
+		}
+		if bf.accessKey == "" || bf.secretKey == "" {
+			return fmt.Errorf("Invalid or missing AWS access key or secret key")
+		}
+	case "minio":
+		if bf.bucket == "" {
+			return fmt.Errorf("Invalid or missing bucket name")
+		}
+		if bf.endpoint == "" {
+			return fmt.Errorf("Invalid or missing endpoint")
+		}
+		if bf.accessKey == "" || bf.secretKey == "" {
+			return fmt.Errorf("Invalid or missing access key or secret key")
+		}
+	case "file":
+		if bf.fileRoot == "" {
+			return fmt.Errorf("Invalid or missing file-root directory")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", bf.name)
+	}
+
+	switch bf.sse {
+	case "", "none":
+	case "aes256", "kms", "c":
+		if bf.name != "s3" && bf.name != "minio" {
+			return fmt.Errorf("--sse is only supported by the s3 and minio backends, not %q", bf.name)
+		}
+	default:
+		return fmt.Errorf("unknown sse mode %q", bf.sse)
+	}
+
+	switch bf.sse {
+	case "kms":
+		if bf.sseKMSKeyID == "" {
+			return fmt.Errorf("Invalid or missing SSE KMS key ID")
+		}
+	case "c":
+		key, err := base64.StdEncoding.DecodeString(bf.sseCKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("Invalid SSE-C customer key: must be base64-encoded and 32 bytes long")
+		}
+		bf.sseCKeyBytes = key
+	}
+
+	return nil
+}
+
+// runBackup zips folderPath, envelope-encrypts it under passphrase, signs
+// the result with the server's private key, and uploads it to S3.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
 	var (
-		folderPath, trustedPublicKeyPath, serverPrivateKeyPath, signingKeyPassword,
-		bucketName, s3KeyName, awsRegion, awsAccessKey, awsSecretKey string
+		folderPath, serverPrivateKeyPath, signingKeyPassword, passphrase, objectKey string
+		reproducible, includeEmptyDirs                                              bool
 	)
 
-	flag.StringVar(&folderPath, "folder", "", "Path to the folder to zip")
-	flag.StringVar(&trustedPublicKeyPath, "tpubkey", "", "Path to the trusted public key for encryption")
-	flag.StringVar(&serverPrivateKeyPath, "sprivkey", "", "Path to the server's private key for signing")
-	flag.StringVar(&signingKeyPassword, "signingpassword", "", "Password for the signing key")
-	flag.StringVar(&bucketName, "bucket", "", "S3 bucket name")
-	flag.StringVar(&s3KeyName, "s3key", "", "Key name to use in S3")
-	flag.StringVar(&awsRegion, "aws-region", "", "AWS bucket region")
-	flag.StringVar(&awsAccessKey, "aws-access-key", "", "AWS access key")
-	flag.StringVar(&awsSecretKey, "aws-secret-key", "", "AWS secret key")
-	flag.Parse()
-
-	// Validate input parameters
-	if err := validateInputs(folderPath, trustedPublicKeyPath, serverPrivateKeyPath, signingKeyPassword, bucketName, s3KeyName, awsRegion, awsAccessKey, awsSecretKey); err != nil {
-		fmt.Println("Error:", err)
-		return
+	fs.StringVar(&folderPath, "folder", "", "Path to the folder to zip")
+	fs.StringVar(&serverPrivateKeyPath, "sprivkey", "", "Path to the server's private key for signing, or a vault:// reference to it")
+	fs.StringVar(&signingKeyPassword, "signingpassword", "", "Password for the signing key, or a vault:// reference to it")
+	fs.StringVar(&passphrase, "passphrase", "", "Passphrase used to derive the envelope encryption key, or a vault:// reference to it")
+	fs.StringVar(&objectKey, "s3key", "", "Key name to store the backup under")
+	fs.BoolVar(&reproducible, "reproducible", false, "Zero entry modification times so an unchanged folder always produces a byte-identical archive")
+	fs.BoolVar(&includeEmptyDirs, "include-empty-dirs", false, "Include empty directories in the archive")
+	bf := registerBackendFlags(fs)
+	vf := registerVaultFlags(fs)
+	fs.Parse(args)
+
+	ctx := context.Background()
+
+	resolver, err := vf.buildResolver()
+	if err != nil {
+		return err
+	}
+	if err := resolveSecrets(ctx, resolver, &serverPrivateKeyPath, &signingKeyPassword, &passphrase, &bf.accessKey, &bf.secretKey, &bf.sseCKey); err != nil {
+		return err
 	}
 
-	// Zip the folder
-	zipBuffer := new(bytes.Buffer)
-	if err := ZipFolder(folderPath, zipBuffer); err != nil {
-		panic(err)
+	if err := validateBackupInputs(folderPath, serverPrivateKeyPath, signingKeyPassword, passphrase, objectKey); err != nil {
+		return err
+	}
+	if err := bf.validate(); err != nil {
+		return err
 	}
 
-	// Encrypt and sign the data
-	encryptedBuffer := new(bytes.Buffer)
-	if err := EncryptAndSign(zipBuffer, trustedPublicKeyPath, serverPrivateKeyPath, encryptedBuffer, signingKeyPassword); err != nil {
-		panic(err)
+	store, err := bf.build()
+	if err != nil {
+		return err
 	}
 
-	// Upload to S3
-	if err := UploadToS3(encryptedBuffer, bucketName, s3KeyName, awsRegion, awsAccessKey, awsSecretKey); err != nil {
-		panic(err)
+	// Zip the folder, streaming archive bytes through a pipe rather than
+	// buffering the whole thing in memory.
+	zipReader, err := ZipFolder(ctx, folderPath, ZipOptions{
+		Reproducible:     reproducible,
+		IncludeEmptyDirs: includeEmptyDirs,
+	})
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+
+	// Envelope-encrypt and sign the zip stream, and feed the result straight
+	// into the upload so peak memory stays bounded by the backend's part
+	// size and concurrency rather than the backup's total size.
+	uploadReader, uploadWriter := io.Pipe()
+	go func() {
+		uploadWriter.CloseWithError(EncryptAndSign(zipReader, serverPrivateKeyPath, signingKeyPassword, passphrase, uploadWriter))
+	}()
+	// If store.Put returns early without draining uploadReader, closing it
+	// here unblocks the pending write above so EncryptAndSign's goroutine
+	// (and its temp spool file) isn't leaked.
+	defer uploadReader.Close()
+
+	if err := store.Put(ctx, objectKey, uploadReader, -1); err != nil {
+		return err
 	}
 
-	fmt.Printf("Successfully uploaded data to %s/%s\n", bucketName, s3KeyName)
+	fmt.Printf("Successfully uploaded data to %s (%s backend)\n", objectKey, bf.name)
+	return nil
 }
 
-// validateInputs performs validation on input parameters and returns an error if validation fails.
-// Parameters:
-// - folderPath: Path to the folder to zip
-// - trustedPublicKeyPath: Path to the trusted public key for encryption
-// - serverPrivateKeyPath: Path to the server's private key for signing
-// - signingKeyPassword: Password for the signing key
-// - bucketName: S3 bucket name
-// - s3KeyName: Key name to use in S3
-// - awsRegion: AWS bucket region
-// - awsAccessKey: AWS access key
-// - awsSecretKey: AWS secret key
-func validateInputs(folderPath, trustedPublicKeyPath, serverPrivateKeyPath, signingKeyPassword, bucketName, s3KeyName, awsRegion, awsAccessKey, awsSecretKey string) error {
-	// Validation logic for input parameters
-	if folderPath == "" || !isDirExists(folderPath) {
-		return fmt.Errorf("Invalid or missing folder path")
+// runRestore downloads a backup artifact from the configured backend,
+// verifies its detached PGP signature against tpubkey, envelope-decrypts it
+// using passphrase, and extracts the resulting zip archive into outputDir.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	var (
+		trustedPublicKeyPath, passphrase, outputDir, objectKey string
+	)
+
+	fs.StringVar(&trustedPublicKeyPath, "tpubkey", "", "Path to the trusted public key used to verify the backup signature")
+	fs.StringVar(&passphrase, "passphrase", "", "Passphrase used to derive the envelope encryption key, or a vault:// reference to it")
+	fs.StringVar(&outputDir, "output", "", "Directory to extract the restored backup into")
+	fs.StringVar(&objectKey, "s3key", "", "Key name the backup is stored under")
+	bf := registerBackendFlags(fs)
+	vf := registerVaultFlags(fs)
+	fs.Parse(args)
+
+	ctx := context.Background()
+
+	resolver, err := vf.buildResolver()
+	if err != nil {
+		return err
+	}
+	if err := resolveSecrets(ctx, resolver, &passphrase, &bf.accessKey, &bf.secretKey, &bf.sseCKey); err != nil {
+		return err
 	}
 
-	if trustedPublicKeyPath == "" || !isFileExists(trustedPublicKeyPath) {
-		return fmt.Errorf("Invalid or missing trusted public key path")
+	if err := validateRestoreInputs(trustedPublicKeyPath, passphrase, outputDir, objectKey); err != nil {
+		return err
+	}
+	if err := bf.validate(); err != nil {
+		return err
+	}
+
+	store, err := bf.build()
+	if err != nil {
+		return err
+	}
+
+	// Checked up front so an SSE-C key mismatch (e.g. a typo'd --sse-c-key)
+	// is reported immediately rather than after streaming the object body
+	// partway through decryption.
+	if err := store.Head(ctx, objectKey); err != nil {
+		return fmt.Errorf("verify backup before restore: %w", err)
+	}
+
+	downloaded, err := store.Get(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	defer downloaded.Close()
+
+	// Decrypt into a spool file rather than memory: archive/zip needs
+	// random access to read the central directory, but the backup itself
+	// may be far larger than available RAM.
+	spool, err := os.CreateTemp("", "go-secure-backup-s3-restore-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if err := VerifyAndDecrypt(downloaded, trustedPublicKeyPath, passphrase, spool); err != nil {
+		return err
+	}
+
+	size, err := spool.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if err := ExtractZip(spool, size, outputDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully restored %s to %s\n", objectKey, outputDir)
+	return nil
+}
+
+// validateBackupInputs performs validation on backup input parameters and
+// returns an error if validation fails.
+func validateBackupInputs(folderPath, serverPrivateKeyPath, signingKeyPassword, passphrase, objectKey string) error {
+	if folderPath == "" || !isDirExists(folderPath) {
+		return fmt.Errorf("Invalid or missing folder path")
 	}
 
-	if serverPrivateKeyPath == "" || !isFileExists(serverPrivateKeyPath) {
+	if serverPrivateKeyPath == "" || (!isArmoredKey(serverPrivateKeyPath) && !isFileExists(serverPrivateKeyPath)) {
 		return fmt.Errorf("Invalid or missing server private key path")
 	}
 
@@ -90,16 +401,34 @@ func validateInputs(folderPath, trustedPublicKeyPath, serverPrivateKeyPath, sign
 		return fmt.Errorf("Invalid or missing signing key password")
 	}
 
-	if awsRegion == "" {
-		return fmt.Errorf("Invalid or missing S3 bucket region")
+	if passphrase == "" {
+		return fmt.Errorf("Invalid or missing envelope encryption passphrase")
+	}
+
+	if objectKey == "" {
+		return fmt.Errorf("Invalid or missing key name")
+	}
+
+	return nil
+}
+
+// validateRestoreInputs performs validation on restore input parameters and
+// returns an error if validation fails.
+func validateRestoreInputs(trustedPublicKeyPath, passphrase, outputDir, objectKey string) error {
+	if trustedPublicKeyPath == "" || !isFileExists(trustedPublicKeyPath) {
+		return fmt.Errorf("Invalid or missing trusted public key path")
+	}
+
+	if passphrase == "" {
+		return fmt.Errorf("Invalid or missing envelope encryption passphrase")
 	}
 
-	if bucketName == "" || s3KeyName == "" {
-		return fmt.Errorf("Invalid or missing S3 bucket name or key name")
+	if outputDir == "" {
+		return fmt.Errorf("Invalid or missing output directory")
 	}
 
-	if awsAccessKey == "" || awsSecretKey == "" {
-		return fmt.Errorf("Invalid or missing AWS access key or secret key")
+	if objectKey == "" {
+		return fmt.Errorf("Invalid or missing key name")
 	}
 
 	return nil
@@ -123,128 +452,411 @@ func isFileExists(path string) bool {
 	return true
 }
 
-// ZipFolder creates a ZIP archive of the given folder.
-// Parameters:
-// - folderPath: Path to the folder to be zipped
-// - zipBuffer: Buffer to write the ZIP archive to
-func ZipFolder(folderPath string, zipBuffer *bytes.Buffer) error {
-	// Create a new zip archive.
-	zipWriter := zip.NewWriter(zipBuffer)
+// isArmoredKey reports whether value is inline armored PGP key material
+// (e.g. resolved from a vault:// reference) rather than a filesystem path.
+func isArmoredKey(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
+
+// manifestName is the path, inside the archive, of the file listing the
+// SHA-256 of every other entry's contents.
+const manifestName = "MANIFEST.sha256"
+
+// ZipOptions controls how ZipFolder lays out the archive it produces.
+type ZipOptions struct {
+	// Reproducible zeroes every entry's modification time, so two runs
+	// against an unchanged tree produce a byte-identical archive.
+	Reproducible bool
+	// IncludeEmptyDirs adds an entry for directories that contain no files,
+	// which are otherwise absent from the archive.
+	IncludeEmptyDirs bool
+}
+
+// zipEntry is a single file, directory, or symlink discovered under a
+// ZipFolder root, named by its slash-separated path relative to that root.
+type zipEntry struct {
+	fsPath      string
+	archiveName string
+	info        os.FileInfo
+}
+
+// ZipFolder streams a ZIP archive of folderPath through a pipe, so the
+// caller can read it without the whole archive ever being buffered in
+// memory. Entries are written in lexicographic path order, so two runs
+// against an unchanged tree always enumerate entries identically; combined
+// with opts.Reproducible, they produce a byte-identical archive. The
+// archive's last entry is manifestName, listing the SHA-256 of every file
+// and symlink target written before it, so the restore command can verify
+// contents independently of the archive's outer signature. The returned
+// reader surfaces any walk or compression error encountered while writing,
+// and must be closed by the caller.
+func ZipFolder(ctx context.Context, folderPath string, opts ZipOptions) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(writeZip(ctx, pw, folderPath, opts))
+	}()
+
+	return pr, nil
+}
+
+// writeZip does the work of ZipFolder, writing the archive to w.
+func writeZip(ctx context.Context, w io.Writer, folderPath string, opts ZipOptions) error {
+	entries, err := zipEntries(folderPath, opts.IncludeEmptyDirs)
+	if err != nil {
+		return err
+	}
+
+	zipWriter := zip.NewWriter(w)
+	manifest := &bytes.Buffer{}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.archiveName == manifestName {
+			return fmt.Errorf("zip: entry %q collides with the reserved manifest name", entry.archiveName)
+		}
+
+		sum, err := writeZipEntry(zipWriter, folderPath, entry, opts.Reproducible)
+		if err != nil {
+			return err
+		}
+		if sum != "" {
+			fmt.Fprintf(manifest, "%s  %s\n", sum, entry.archiveName)
+		}
+	}
+
+	manifestHeader := &zip.FileHeader{Name: manifestName, Method: zip.Deflate}
+	if opts.Reproducible {
+		manifestHeader.Modified = time.Time{}
+	}
+	manifestWriter, err := zipWriter.CreateHeader(manifestHeader)
+	if err != nil {
+		return err
+	}
+	if _, err := manifestWriter.Write(manifest.Bytes()); err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// zipEntries walks root and returns every file, directory, and symlink
+// under it (root itself excluded) in lexicographic archiveName order.
+// Directories are included only when they contain no entries of their own
+// and includeEmptyDirs is set.
+func zipEntries(root string, includeEmptyDirs bool) ([]zipEntry, error) {
+	nonEmptyDir := map[string]bool{}
+	var entries []zipEntry
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		archiveName := filepath.ToSlash(rel)
+		if archiveName == ".." || strings.HasPrefix(archiveName, "../") {
+			return fmt.Errorf("zip: entry %q escapes root directory", path)
+		}
 
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
 		if !info.IsDir() {
-			data, err := ioutil.ReadFile(path)
-			if err != nil {
+			nonEmptyDir[filepath.ToSlash(filepath.Dir(rel))] = true
+		}
+		entries = append(entries, zipEntry{fsPath: path, archiveName: archiveName, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !includeEmptyDirs {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if e.info.IsDir() && !nonEmptyDir[e.archiveName] {
+				continue
+			}
+			filtered = append(filtered, e)
+		}
+		entries = filtered
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].archiveName < entries[j].archiveName })
+	return entries, nil
+}
+
+// writeZipEntry writes a single entry to zipWriter and returns the hex
+// SHA-256 of its contents (the file's bytes, or a symlink's target), or ""
+// for directories, which have no contents to hash.
+func writeZipEntry(zipWriter *zip.Writer, root string, e zipEntry, reproducible bool) (string, error) {
+	header, err := zip.FileInfoHeader(e.info)
+	if err != nil {
+		return "", err
+	}
+	header.Name = e.archiveName
+	header.Method = zip.Deflate
+	if reproducible {
+		// zip.FileInfoHeader already populated Modified (and, from it, the
+		// legacy MS-DOS ModifiedDate/ModifiedTime fields) from the file's
+		// real mtime. Zeroing Modified alone leaves those legacy fields in
+		// place, so clear them too.
+		header.Modified = time.Time{}
+		header.ModifiedTime = 0
+		header.ModifiedDate = 0
+	}
+
+	switch {
+	case e.info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(e.fsPath)
+		if err != nil {
+			return "", err
+		}
+		if symlinkEscapesRoot(root, e.fsPath, target) {
+			return "", fmt.Errorf("zip: symlink %q escapes root directory", e.archiveName)
+		}
+		header.SetMode(os.ModeSymlink | 0777)
+
+		dst, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(dst, target); err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256([]byte(target))
+		return hex.EncodeToString(sum[:]), nil
+
+	case e.info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+		_, err := zipWriter.CreateHeader(header)
+		return "", err
+
+	default:
+		dst, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return "", err
+		}
+
+		src, err := os.Open(e.fsPath)
+		if err != nil {
+			return "", err
+		}
+		defer src.Close()
+
+		hasher := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(dst, hasher), src); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+}
+
+// symlinkEscapesRoot reports whether target, the link target of the symlink
+// at fsPath, resolves to a path outside root. Relative targets are resolved
+// against the symlink's own directory, matching how the filesystem would
+// follow them.
+func symlinkEscapesRoot(root, fsPath, target string) bool {
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(fsPath), resolved)
+	}
+	resolved = filepath.Clean(resolved)
+	root = filepath.Clean(root)
+	return resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator))
+}
+
+// ExtractZip extracts a zip archive read from r (size bytes long) into
+// destDir, creating any intermediate directories as needed. Symlink entries
+// (as written by ZipFolder) are recreated as symlinks pointing at their
+// stored target. Entries that would escape destDir are rejected (zip-slip
+// defense).
+func ExtractZip(r io.ReaderAt, size int64, destDir string) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range reader.File {
+		targetPath := filepath.Join(destDir, f.Name)
+		if !strings.HasPrefix(targetPath, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("zip entry %q escapes destination directory", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(targetPath, f.Mode()); err != nil {
 				return err
 			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return err
+		}
 
-			f, err := zipWriter.Create(strings.TrimPrefix(path, folderPath+"/"))
+		if f.Mode()&os.ModeSymlink != 0 {
+			rc, err := f.Open()
 			if err != nil {
 				return err
 			}
-
-			_, err = f.Write(data)
+			target, err := io.ReadAll(rc)
+			rc.Close()
 			if err != nil {
 				return err
 			}
+			os.Remove(targetPath)
+			if err := os.Symlink(string(target), targetPath); err != nil {
+				return err
+			}
+			continue
 		}
-		return nil
-	})
 
-	if err != nil {
-		return err
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		out, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
+		if err != nil {
+			rc.Close()
+			return err
+		}
+
+		_, err = io.Copy(out, rc)
+		rc.Close()
+		out.Close()
+		if err != nil {
+			return err
+		}
 	}
 
-	// Close the archive.
-	return zipWriter.Close()
+	return nil
 }
 
-// EncryptAndSign encrypts the zipBuffer using the trustedPublicKeyPath and signs it using the serverPrivateKeyPath.
-// Parameters:
-// - zipBuffer: The data to be encrypted and signed
-// - trustedPublicKeyPath: Path to the trusted public key for encryption
-// - serverPrivateKeyPath: Path to the server's private key for signing
-// - encryptedBuffer: Buffer to write the encrypted data to
-// - signingKeyPassword: Password for decrypting the signing private key
-func EncryptAndSign(zipBuffer *bytes.Buffer, trustedPublicKeyPath string, serverPrivateKeyPath string, encryptedBuffer *bytes.Buffer, signingKeyPassword string) error {
-	// Read the trusted public key
-	publicKeyFile, err := os.Open(trustedPublicKeyPath)
+// EncryptAndSign envelope-encrypts src under a KEK derived from passphrase
+// (see envelope.go) and writes a detached PGP signature, produced with the
+// server private key, over the resulting ciphertext. The output written to
+// dst is [sig frame][envelope header frame][chunk frames...][terminator
+// frame]. Signing requires a complete pass over the ciphertext before its
+// signature is known, so the ciphertext is spooled to a temp file rather
+// than held in memory; src itself is still streamed through encryption one
+// envelope chunk at a time.
+func EncryptAndSign(src io.Reader, serverPrivateKey, signingKeyPassword, passphrase string, dst io.Writer) error {
+	signer, err := loadSigningEntity(serverPrivateKey, signingKeyPassword)
 	if err != nil {
 		return err
 	}
-	defer publicKeyFile.Close()
 
-	publicKeyList, err := openpgp.ReadArmoredKeyRing(publicKeyFile)
+	spool, err := os.CreateTemp("", "go-secure-backup-s3-envelope-*.bin")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
 
-	// Read the server private key
-	privateKeyFile, err := os.Open(serverPrivateKeyPath)
-	if err != nil {
+	if err := sealEnvelopeStream(spool, src, passphrase); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
-	defer privateKeyFile.Close()
 
-	privateKeyList, err := openpgp.ReadArmoredKeyRing(privateKeyFile)
-	if err != nil {
+	signature := new(bytes.Buffer)
+	if err := openpgp.DetachSign(signature, signer, spool, nil); err != nil {
+		return err
+	}
+	if err := writeFrame(dst, signature.Bytes()); err != nil {
 		return err
 	}
 
-	// Decrypt the server private key using the provided password
-	for _, entity := range privateKeyList {
-		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
-			// Check if the private key is encrypted
-			err := entity.PrivateKey.Decrypt([]byte(signingKeyPassword))
-			if err != nil {
-				return err
-			}
-		}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
+	_, err = io.Copy(dst, spool)
+	return err
+}
 
-	// Encrypt and Sign
-	w, err := openpgp.Encrypt(encryptedBuffer, publicKeyList, privateKeyList[0], nil, nil)
+// VerifyAndDecrypt streams src (an artifact produced by EncryptAndSign),
+// verifying its leading detached PGP signature against trustedPublicKeyPath
+// while simultaneously envelope-decrypting the signed ciphertext under
+// passphrase and writing the recovered zip archive to dst. At most one
+// envelope chunk is held in memory at a time.
+func VerifyAndDecrypt(src io.Reader, trustedPublicKeyPath, passphrase string, dst io.Writer) error {
+	publicKeyFile, err := os.Open(trustedPublicKeyPath)
 	if err != nil {
 		return err
 	}
+	defer publicKeyFile.Close()
 
-	_, err = w.Write(zipBuffer.Bytes())
+	publicKeyList, err := openpgp.ReadArmoredKeyRing(publicKeyFile)
 	if err != nil {
 		return err
 	}
 
-	// Close the writer to finalize encryption.
-	return w.Close()
-}
+	signature, err := readFrame(src)
+	if err != nil {
+		return fmt.Errorf("read signature: %w", err)
+	}
 
-// UploadToS3 uploads the encryptedBuffer to S3.
-// Parameters:
-// - encryptedBuffer: The encrypted data to be uploaded
-// - bucketName: S3 bucket name
-// - fileName: Key name to use in S3
-// - region: AWS bucket region
-// - accessKey: AWS access key
-// - secretKey: AWS secret key
-func UploadToS3(encryptedBuffer *bytes.Buffer, bucketName, fileName, region, accessKey, secretKey string) error {
-	// Create AWS credentials using access key and secret key
-	creds := credentials.NewStaticCredentials(accessKey, secretKey, "")
+	// signedPR carries the ciphertext exactly once, as openEnvelopeStream
+	// consumes src, so verification and decryption happen in the same pass
+	// over the stream rather than requiring src to be read twice.
+	signedPR, signedPW := io.Pipe()
+	tee := io.TeeReader(src, signedPW)
+	// If CheckDetachedSignature returns early without draining signedPR
+	// (e.g. no matching signer in the keyring), closing it here unblocks
+	// the decode goroutine's pending write instead of leaking it.
+	defer signedPR.Close()
+
+	decodeDone := make(chan error, 1)
+	go func() {
+		err := openEnvelopeStream(dst, tee, passphrase)
+		decodeDone <- err
+		signedPW.CloseWithError(err)
+	}()
+
+	if _, err := openpgp.CheckDetachedSignature(publicKeyList, signedPR, bytes.NewReader(signature)); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
 
-	// Set up AWS session with your credentials
-	awsSession, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region), // Replace with your desired AWS region
-		Credentials: creds,
-	})
+	return <-decodeDone
+}
+
+// loadSigningEntity reads serverPrivateKey, which is either a path to an
+// armored private key file or (when resolved from a vault:// reference)
+// inline armored key text, and decrypts it with signingKeyPassword.
+func loadSigningEntity(serverPrivateKey, signingKeyPassword string) (*openpgp.Entity, error) {
+	var armored io.Reader
+	if isArmoredKey(serverPrivateKey) {
+		armored = strings.NewReader(serverPrivateKey)
+	} else {
+		privateKeyFile, err := os.Open(serverPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		defer privateKeyFile.Close()
+		armored = privateKeyFile
+	}
 
+	privateKeyList, err := openpgp.ReadArmoredKeyRing(armored)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	svc := s3.New(awsSession)
+	for _, entity := range privateKeyList {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt([]byte(signingKeyPassword)); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(fileName),
-		Body:   bytes.NewReader(encryptedBuffer.Bytes()),
-	})
-	return err
-}
\ No newline at end of file
+	return privateKeyList[0], nil
+}